@@ -0,0 +1,76 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package command implements the allmark CLI subcommands.
+package command
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/andreaskoch/allmark2/common/config"
+	"github.com/andreaskoch/allmark2/common/index"
+	"github.com/andreaskoch/allmark2/common/logger"
+	"github.com/andreaskoch/allmark2/common/paths/webpaths"
+	"github.com/andreaskoch/allmark2/services/conversion"
+	"github.com/andreaskoch/allmark2/services/export"
+	"github.com/andreaskoch/allmark2/services/search"
+	"github.com/andreaskoch/allmark2/ui/web/server/handler"
+	"github.com/andreaskoch/allmark2/ui/web/server/update"
+)
+
+// Build implements the `allmark build` subcommand, which renders every route
+// the web server would serve into a static output directory.
+func Build(logger logger.Logger, config *config.Config, itemIndex *index.Index, converter conversion.Converter, searcher *search.ItemSearch, args []string) error {
+
+	flagSet := flag.NewFlagSet("build", flag.ExitOnError)
+	outDir := flagSet.String("out", "./public", "the directory the static site is rendered into")
+
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	patherFactory := webpaths.NewFactory(logger, itemIndex)
+
+	exporter := export.New(logger, itemIndex, config.ThemeFolder())
+
+	exporter.AddRoute("/robots.txt", handler.NewRobotsTxtHandler(logger, config, itemIndex, patherFactory).Func())
+	exporter.AddRoute("/sitemap.xml", handler.NewXmlSitemapHandler(logger, config, itemIndex, patherFactory).Func())
+	exporter.AddRoute("/tags.html", handler.NewTagsHandler(logger, config, itemIndex, patherFactory).Func())
+	exporter.AddRoute("/sitemap.html", handler.NewSitemapHandler(logger, config, itemIndex, patherFactory).Func())
+	exporter.AddRoute("/feed.rss", handler.NewRssHandler(logger, config, itemIndex, patherFactory, converter).Func())
+	exporter.AddRoute("/opensearch.xml", handler.NewOpenSearchDescriptionHandler(logger, config, patherFactory, itemIndex).Func())
+	exporter.AddRoute("/search.json", handler.NewTypeAheadSearchHandler(logger, config, patherFactory, itemIndex, searcher).Func())
+	exporter.AddRoute("/titles.json", handler.NewTypeAheadTitlesHandler(logger, config, patherFactory, itemIndex).Func())
+
+	// the exported pages are static, so the item handler is given an
+	// update hub that never fires rather than a nil one it would have to
+	// guard against
+	updateHub := update.NewHub(logger.With("handler", "update-hub"), noopUpdateHub{})
+	go updateHub.Run()
+
+	for _, item := range itemIndex.Items() {
+		route := "/" + item.RelativePath()
+
+		exporter.AddRoute(route, handler.NewItemHandler(logger, config, itemIndex, patherFactory, converter, updateHub).Func())
+		exporter.AddRoute(route+".json", handler.NewJsonHandler(logger, config, itemIndex, patherFactory, converter).Func())
+		exporter.AddRoute(route+".rtf", handler.NewRtfHandler(logger, config, itemIndex, patherFactory).Func())
+		exporter.AddRoute(route+".print", handler.NewPrintHandler(logger, config, itemIndex, patherFactory, converter).Func())
+	}
+
+	if err := exporter.Export(*outDir); err != nil {
+		return fmt.Errorf("build failed: %v", err)
+	}
+
+	logger.Info("Static site exported to %q\n", *outDir)
+	return nil
+}
+
+// noopUpdateHub is a dataaccess.UpdateHub that never notifies subscribers.
+// A static export has no live server to push reloads to, so wiring the real
+// update hub through the item handler would just sit on an idle channel.
+type noopUpdateHub struct{}
+
+func (noopUpdateHub) Subscribe(func(route string)) {}
+func (noopUpdateHub) Update(route string)          {}
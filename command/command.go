@@ -0,0 +1,34 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package command
+
+import (
+	"fmt"
+
+	"github.com/andreaskoch/allmark2/common/config"
+	"github.com/andreaskoch/allmark2/common/index"
+	"github.com/andreaskoch/allmark2/common/logger"
+	"github.com/andreaskoch/allmark2/services/conversion"
+	"github.com/andreaskoch/allmark2/services/search"
+)
+
+// Dispatch runs the subcommand named by args[0] with the remaining
+// arguments. It is the single entry point the CLI's main package should call
+// once the repository has been indexed.
+func Dispatch(logger logger.Logger, config *config.Config, itemIndex *index.Index, converter conversion.Converter, searcher *search.ItemSearch, args []string) error {
+
+	if len(args) == 0 {
+		return fmt.Errorf("no subcommand given (expected %q)", "build")
+	}
+
+	name, rest := args[0], args[1:]
+
+	switch name {
+	case "build":
+		return Build(logger, config, itemIndex, converter, searcher, rest)
+	default:
+		return fmt.Errorf("unknown subcommand %q (expected %q)", name, "build")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+// CSP holds the Content-Security-Policy directives served with every
+// response. Each key is a directive name ("default-src", "script-src", ...)
+// and each value is the list of sources allowed for that directive.
+type CSP map[string][]string
+
+// DefaultCSP returns a restrictive baseline policy that still allows the
+// update websocket to connect.
+func DefaultCSP() CSP {
+	return CSP{
+		"default-src": {"'self'"},
+		"script-src":  {"'self'"},
+		"style-src":   {"'self'"},
+		"img-src":     {"'self'", "data:"},
+		"connect-src": {"'self'", "ws:", "wss:"},
+	}
+}
+
+// Header renders the policy as the value of a Content-Security-Policy
+// header, with directives sorted so the output is deterministic.
+func (csp CSP) Header() string {
+
+	directives := make([]string, 0, len(csp))
+	for directive := range csp {
+		directives = append(directives, directive)
+	}
+	sort.Strings(directives)
+
+	parts := make([]string, 0, len(directives))
+	for _, directive := range directives {
+		sources := csp[directive]
+		if len(sources) == 0 {
+			continue
+		}
+
+		parts = append(parts, directive+" "+strings.Join(sources, " "))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// WithSource returns a copy of csp with source appended to the given
+// directive.
+func (csp CSP) WithSource(directive, source string) CSP {
+
+	merged := make(CSP, len(csp))
+	for key, values := range csp {
+		merged[key] = append([]string{}, values...)
+	}
+
+	merged[directive] = append(merged[directive], source)
+	return merged
+}
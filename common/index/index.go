@@ -0,0 +1,93 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package index holds the in-memory representation of a content
+// repository: a flat, route-addressable collection of items that the web
+// server, feeds and the static exporter all render from.
+package index
+
+import "time"
+
+// MetaData carries the non-content attributes of an Item.
+type MetaData struct {
+	// Date is the item's publish/modification date, used to order feeds
+	// and to derive Last-Modified/If-Modified-Since responses.
+	Date time.Time
+
+	// CommitSha and CommitAuthor are populated by VCS-backed
+	// dataaccess.Repository implementations (e.g. the git backend) so
+	// templates can render "last edited by" information. Both are empty
+	// when the content source doesn't track history.
+	CommitSha    string
+	CommitAuthor string
+}
+
+// Item is a single page of content together with its place in the tree.
+type Item struct {
+	Title    string
+	MetaData MetaData
+	Childs   []*Item
+
+	relativePath string
+}
+
+// NewItem creates an Item for relativePath, the route it is served under
+// relative to the repository root (the root item itself uses "").
+func NewItem(relativePath, title string, date time.Time) *Item {
+	return &Item{
+		Title:        title,
+		MetaData:     MetaData{Date: date},
+		relativePath: relativePath,
+	}
+}
+
+// RelativePath returns the item's route relative to the repository root,
+// e.g. "blog/my-post". The root item returns "".
+func (item *Item) RelativePath() string {
+	return item.relativePath
+}
+
+// Index is a snapshot of every Item in a content repository, addressable
+// both as a flat list and by the web route it is served under.
+type Index struct {
+	root    *Item
+	items   []*Item
+	byRoute map[string]*Item
+}
+
+// New assembles items into an Index. The item whose RelativePath is ""
+// becomes the index's Root.
+func New(items []*Item) *Index {
+
+	idx := &Index{
+		items:   items,
+		byRoute: make(map[string]*Item, len(items)),
+	}
+
+	for _, item := range items {
+		idx.byRoute["/"+item.RelativePath()] = item
+
+		if item.RelativePath() == "" {
+			idx.root = item
+		}
+	}
+
+	return idx
+}
+
+// Items returns every item in the index.
+func (idx *Index) Items() []*Item {
+	return idx.items
+}
+
+// Root returns the repository's root item, or nil if none was indexed.
+func (idx *Index) Root() *Item {
+	return idx.root
+}
+
+// ItemForRoute returns the item serving route (as in r.URL.Path), or nil if
+// there is none.
+func (idx *Index) ItemForRoute(route string) *Item {
+	return idx.byRoute[route]
+}
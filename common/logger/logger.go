@@ -0,0 +1,84 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package logger provides a small, structured logging facade used
+// throughout allmark. It is backed by the standard library's log/slog
+// package so callers get levelled, attributable logging without pulling
+// in a third-party dependency.
+package logger
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Logger is the logging interface used across all allmark packages.
+type Logger interface {
+	Debug(format string, v ...interface{})
+	Info(format string, v ...interface{})
+	Warn(format string, v ...interface{})
+	Error(format string, v ...interface{})
+
+	// With returns a derived Logger that attaches the given key/value
+	// pairs to every subsequent log entry.
+	With(args ...interface{}) Logger
+}
+
+// Format selects the handler used to render log entries.
+type Format int
+
+const (
+	TextFormat Format = iota
+	JSONFormat
+)
+
+// New creates a Logger that writes levelled log entries to stderr using the
+// given format (JSONFormat enables structured JSON output, anything else
+// falls back to human readable text).
+func New(level slog.Level, format Format) Logger {
+
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if format == JSONFormat {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+
+	return &slogLogger{slog.New(handler)}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l *slogLogger) Debug(format string, v ...interface{}) {
+	l.logger.Debug(sprintf(format, v...))
+}
+
+func (l *slogLogger) Info(format string, v ...interface{}) {
+	l.logger.Info(sprintf(format, v...))
+}
+
+func (l *slogLogger) Warn(format string, v ...interface{}) {
+	l.logger.Warn(sprintf(format, v...))
+}
+
+func (l *slogLogger) Error(format string, v ...interface{}) {
+	l.logger.Error(sprintf(format, v...))
+}
+
+func (l *slogLogger) With(args ...interface{}) Logger {
+	return &slogLogger{l.logger.With(args...)}
+}
+
+func sprintf(format string, v ...interface{}) string {
+	if len(v) == 0 {
+		return format
+	}
+
+	return fmt.Sprintf(format, v...)
+}
@@ -0,0 +1,382 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package git implements a dataaccess.Repository that uses a git repository
+// as the content source instead of the local filesystem.
+package git
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/andreaskoch/allmark2/common/index"
+	"github.com/andreaskoch/allmark2/common/logger"
+	"github.com/andreaskoch/allmark2/common/util/fsutil"
+	"github.com/andreaskoch/allmark2/dataaccess"
+)
+
+// Config holds the settings required to track a git repository as a content
+// source.
+type Config struct {
+	// Url is the remote or local path of the git repository.
+	Url string
+
+	// WorkDir is the directory the repository is cloned/checked out into.
+	WorkDir string
+
+	// PollInterval controls how often `git fetch` is run to look for
+	// remote changes. A zero value disables polling.
+	PollInterval time.Duration
+
+	// WebhookSecret, when set, is used to verify the X-Hub-Signature-256
+	// header of incoming webhook requests. Requests without a valid
+	// signature are rejected. Leave empty to disable verification (e.g.
+	// when the webhook route is only reachable from a trusted network).
+	WebhookSecret string
+}
+
+// New clones (or opens) the repository described by config and returns a
+// dataaccess.Repository backed by it.
+func New(logger logger.Logger, config Config, updateHub dataaccess.UpdateHub) (*Repository, error) {
+
+	repo := &Repository{
+		logger:    logger,
+		config:    config,
+		updateHub: updateHub,
+	}
+
+	if err := repo.cloneOrOpen(); err != nil {
+		return nil, err
+	}
+
+	if err := repo.reindex(); err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// Repository is a dataaccess.Repository backed by a git working copy.
+type Repository struct {
+	logger    logger.Logger
+	config    Config
+	updateHub dataaccess.UpdateHub
+
+	indexMutex sync.RWMutex
+	index      *index.Index
+}
+
+// Path returns the local working directory the repository was checked out into.
+func (repo *Repository) Path() string {
+	return repo.config.WorkDir
+}
+
+// Index returns the item index built from the repository's tracked
+// markdown files as of the last clone/fetch/pull.
+func (repo *Repository) Index() *index.Index {
+	repo.indexMutex.RLock()
+	defer repo.indexMutex.RUnlock()
+
+	return repo.index
+}
+
+func (repo *Repository) cloneOrOpen() error {
+
+	if fsutil.DirectoryExists(repo.config.WorkDir) {
+		return repo.run("fetch", "--all")
+	}
+
+	// the work dir does not exist yet, so clone can't use it as cmd.Dir
+	cmd := exec.Command("git", "clone", repo.config.Url, repo.config.WorkDir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git clone failed: %v\n%s", err, output)
+	}
+
+	return nil
+}
+
+// Poll starts a goroutine that periodically runs `git fetch` and pushes an
+// update through updateHub whenever new commits are found. It returns
+// immediately; call the returned stop function to end polling.
+func (repo *Repository) Poll() (stop func()) {
+
+	if repo.config.PollInterval <= 0 {
+		return func() {}
+	}
+
+	stopChan := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(repo.config.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := repo.sync(); err != nil {
+					repo.logger.Error("Unable to sync git repository: %v", err)
+				}
+
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	return func() { close(stopChan) }
+}
+
+// WebhookHandler returns an http.HandlerFunc suitable for registration on
+// the /hooks/git route. Every POST triggers an immediate sync. When
+// config.WebhookSecret is set, requests must carry a valid GitHub-style
+// "X-Hub-Signature-256: sha256=<hex>" header or they are rejected.
+func (repo *Repository) WebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Unable to read request body", http.StatusBadRequest)
+			return
+		}
+
+		if repo.config.WebhookSecret != "" && !repo.validSignature(body, r.Header.Get("X-Hub-Signature-256")) {
+			repo.logger.Warn("Rejected git webhook request with an invalid signature\n")
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		if err := repo.sync(); err != nil {
+			repo.logger.Error("Unable to sync git repository after webhook: %v", err)
+			http.Error(w, "Unable to sync repository", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// validSignature reports whether signatureHeader is a valid
+// "sha256=<hex>" HMAC-SHA256 signature of body using config.WebhookSecret.
+func (repo *Repository) validSignature(body []byte, signatureHeader string) bool {
+	const prefix = "sha256="
+	if !strings.HasPrefix(signatureHeader, prefix) {
+		return false
+	}
+
+	expectedMAC, err := hex.DecodeString(strings.TrimPrefix(signatureHeader, prefix))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(repo.config.WebhookSecret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expectedMAC)
+}
+
+// sync pulls the latest changes and, if anything changed, rebuilds the item
+// index and notifies updateHub.
+func (repo *Repository) sync() error {
+
+	before, err := repo.headCommit()
+	if err != nil {
+		return err
+	}
+
+	if err := repo.run("pull", "--ff-only"); err != nil {
+		return err
+	}
+
+	after, err := repo.headCommit()
+	if err != nil {
+		return err
+	}
+
+	if before != after {
+		repo.logger.Info("Git repository updated: %s -> %s\n", before, after)
+
+		if err := repo.reindex(); err != nil {
+			repo.logger.Error("Unable to rebuild the item index: %v", err)
+		}
+
+		repo.updateHub.Update(repo.config.WorkDir)
+	}
+
+	return nil
+}
+
+// reindex rebuilds the item index from the current working copy and stores
+// it for Index to return.
+func (repo *Repository) reindex() error {
+
+	idx, err := repo.BuildIndex()
+	if err != nil {
+		return fmt.Errorf("unable to build the item index: %v", err)
+	}
+
+	repo.indexMutex.Lock()
+	repo.index = idx
+	repo.indexMutex.Unlock()
+
+	return nil
+}
+
+// BuildIndex walks the markdown files tracked by git and assembles them
+// into an index.Index, attaching each item's last commit SHA, author and
+// date via CommitInfoFor so templates can render "last edited by" info.
+func (repo *Repository) BuildIndex() (*index.Index, error) {
+
+	trackedPaths, err := repo.trackedMarkdownFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*index.Item, 0, len(trackedPaths))
+
+	for _, trackedPath := range trackedPaths {
+		commit, err := repo.CommitInfoFor(trackedPath)
+		if err != nil {
+			repo.logger.Warn("Unable to determine commit info for %q: %v", trackedPath, err)
+		}
+
+		relativePath := relativePathFor(trackedPath)
+
+		item := index.NewItem(relativePath, titleFor(relativePath), commit.Date)
+		item.MetaData.CommitSha = commit.Sha
+		item.MetaData.CommitAuthor = commit.Author
+
+		items = append(items, item)
+	}
+
+	return index.New(items), nil
+}
+
+// trackedMarkdownFiles returns the repository-relative paths of every
+// markdown file git tracks.
+func (repo *Repository) trackedMarkdownFiles() ([]string, error) {
+
+	output, err := repo.runOutput("ls-files", "--", "*.md")
+	if err != nil {
+		return nil, err
+	}
+
+	if output == "" {
+		return nil, nil
+	}
+
+	return strings.Split(output, "\n"), nil
+}
+
+// relativePathFor turns a tracked file path into the route it is served
+// under. "index.md"/"README.md" files are served under the route of the
+// directory that contains them (the repository root's own index/readme
+// maps to the empty route, i.e. the site root); every other file is served
+// under its path with the ".md" extension stripped.
+func relativePathFor(trackedPath string) string {
+
+	dir, base := filepath.Split(trackedPath)
+
+	if strings.EqualFold(base, "index.md") || strings.EqualFold(base, "README.md") {
+		return filepath.ToSlash(strings.TrimSuffix(dir, "/"))
+	}
+
+	return filepath.ToSlash(strings.TrimSuffix(trackedPath, filepath.Ext(trackedPath)))
+}
+
+// titleFor derives a human-readable title from an item's relative path,
+// e.g. "blog/my-first-post" becomes "My First Post".
+func titleFor(relativePath string) string {
+
+	if relativePath == "" {
+		return "Home"
+	}
+
+	base := filepath.Base(relativePath)
+	base = strings.ReplaceAll(base, "-", " ")
+	base = strings.ReplaceAll(base, "_", " ")
+
+	words := strings.Fields(base)
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// CommitInfo describes the git metadata attached to repository items so
+// templates can render "last edited by" style information.
+type CommitInfo struct {
+	Sha    string
+	Author string
+	Date   time.Time
+}
+
+// CommitInfoFor returns the last commit that touched the given path.
+func (repo *Repository) CommitInfoFor(path string) (CommitInfo, error) {
+
+	sha, err := repo.runOutput("log", "-1", "--format=%H", "--", path)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+
+	author, err := repo.runOutput("log", "-1", "--format=%an", "--", path)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+
+	dateString, err := repo.runOutput("log", "-1", "--format=%aI", "--", path)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+
+	date, err := time.Parse(time.RFC3339, dateString)
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf("unable to parse commit date %q: %v", dateString, err)
+	}
+
+	return CommitInfo{Sha: sha, Author: author, Date: date}, nil
+}
+
+func (repo *Repository) headCommit() (string, error) {
+	return repo.runOutput("rev-parse", "HEAD")
+}
+
+func (repo *Repository) run(args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repo.config.WorkDir
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %v failed: %v\n%s", args, err, output)
+	}
+
+	return nil
+}
+
+func (repo *Repository) runOutput(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repo.config.WorkDir
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %v failed: %v", args, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func signatureFor(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	repo := &Repository{config: Config{WebhookSecret: "s3cr3t"}}
+
+	cases := []struct {
+		name      string
+		signature string
+		want      bool
+	}{
+		{"valid signature", signatureFor("s3cr3t", body), true},
+		{"wrong secret", signatureFor("wrong", body), false},
+		{"tampered body", signatureFor("s3cr3t", []byte("tampered")), false},
+		{"missing prefix", hex.EncodeToString([]byte("not-a-signature")), false},
+		{"malformed hex", "sha256=not-hex", false},
+		{"empty header", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := repo.validSignature(body, c.signature); got != c.want {
+				t.Errorf("validSignature(%q) = %v, want %v", c.signature, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRelativePathFor(t *testing.T) {
+	cases := []struct {
+		trackedPath string
+		want        string
+	}{
+		{"index.md", ""},
+		{"README.md", ""},
+		{"blog/index.md", "blog"},
+		{"blog/my-first-post.md", "blog/my-first-post"},
+	}
+
+	for _, c := range cases {
+		if got := relativePathFor(c.trackedPath); got != c.want {
+			t.Errorf("relativePathFor(%q) = %q, want %q", c.trackedPath, got, c.want)
+		}
+	}
+}
+
+func TestTitleFor(t *testing.T) {
+	cases := []struct {
+		relativePath string
+		want         string
+	}{
+		{"", "Home"},
+		{"blog/my-first-post", "My First Post"},
+		{"about_us", "About Us"},
+	}
+
+	for _, c := range cases {
+		if got := titleFor(c.relativePath); got != c.want {
+			t.Errorf("titleFor(%q) = %q, want %q", c.relativePath, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package listenfd adopts listening sockets passed in by a supervisor (e.g.
+// systemd socket activation) via the LISTEN_FDS/LISTEN_PID environment
+// variables, so a server can hand off its listeners across a restart
+// without dropping connections.
+package listenfd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFdsStart is the file descriptor number of the first socket passed by
+// the supervisor, per the systemd socket activation protocol.
+const listenFdsStart = 3
+
+// Listeners returns the listening sockets passed in by the supervisor, in
+// the order they were passed, or nil if none were passed (e.g. LISTEN_PID
+// does not match the current process, or LISTEN_FDS is unset/zero).
+func Listeners() ([]net.Listener, error) {
+
+	count, ok := inherited()
+	if !ok {
+		return nil, nil
+	}
+
+	listeners := make([]net.Listener, 0, count)
+
+	for offset := 0; offset < count; offset++ {
+		fd := uintptr(listenFdsStart + offset)
+
+		file := os.NewFile(fd, fmt.Sprintf("listenfd-%d", offset))
+		listener, err := net.FileListener(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to adopt inherited socket %d: %v", fd, err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// inherited reports how many sockets the supervisor passed to this process.
+func inherited() (count int, ok bool) {
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return 0, false
+	}
+
+	count, err = strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return 0, false
+	}
+
+	return count, true
+}
@@ -0,0 +1,44 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cspgenerator scans rendered HTML for inline <script> and <style>
+// blocks and computes their sha256 hashes, so a Content-Security-Policy can
+// allow them by hash instead of falling back to 'unsafe-inline'.
+package cspgenerator
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"regexp"
+
+	"github.com/andreaskoch/allmark2/common/config"
+)
+
+var (
+	inlineScriptPattern = regexp.MustCompile(`(?is)<script(?:\s[^>]*)?>(.*?)</script>`)
+	inlineStylePattern  = regexp.MustCompile(`(?is)<style(?:\s[^>]*)?>(.*?)</style>`)
+)
+
+// Generate scans html for inline script/style blocks and returns a copy of
+// baseline with a 'sha256-...' source added to script-src/style-src for each
+// block found. When html holds no such block, baseline itself is returned.
+func Generate(baseline config.CSP, html []byte) config.CSP {
+
+	csp := baseline
+
+	for _, match := range inlineScriptPattern.FindAllSubmatch(html, -1) {
+		csp = csp.WithSource("script-src", hashSource(match[1]))
+	}
+
+	for _, match := range inlineStylePattern.FindAllSubmatch(html, -1) {
+		csp = csp.WithSource("style-src", hashSource(match[1]))
+	}
+
+	return csp
+}
+
+func hashSource(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
@@ -0,0 +1,84 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cspgenerator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andreaskoch/allmark2/common/config"
+)
+
+func TestGenerateAddsHashForInlineScript(t *testing.T) {
+	html := []byte(`<html><body><script>alert(1)</script></body></html>`)
+
+	csp := Generate(config.DefaultCSP(), html)
+
+	want := hashSource([]byte("alert(1)"))
+	if !contains(csp["script-src"], want) {
+		t.Errorf("script-src = %v, want it to contain %q", csp["script-src"], want)
+	}
+}
+
+func TestGenerateAddsHashForInlineStyle(t *testing.T) {
+	html := []byte(`<html><head><style>body{color:red}</style></head></html>`)
+
+	csp := Generate(config.DefaultCSP(), html)
+
+	want := hashSource([]byte("body{color:red}"))
+	if !contains(csp["style-src"], want) {
+		t.Errorf("style-src = %v, want it to contain %q", csp["style-src"], want)
+	}
+}
+
+func TestGenerateHandlesMultipleBlocks(t *testing.T) {
+	html := []byte(`<script>one()</script><script>two()</script>`)
+
+	csp := Generate(config.DefaultCSP(), html)
+
+	for _, script := range []string{"one()", "two()"} {
+		if !contains(csp["script-src"], hashSource([]byte(script))) {
+			t.Errorf("script-src = %v, want it to contain a hash for %q", csp["script-src"], script)
+		}
+	}
+}
+
+func TestGenerateLeavesBaselineUntouchedWithoutInlineContent(t *testing.T) {
+	baseline := config.DefaultCSP()
+	html := []byte(`<html><body><p>no scripts here</p></body></html>`)
+
+	csp := Generate(baseline, html)
+
+	if csp.Header() != baseline.Header() {
+		t.Errorf("Generate modified the policy despite no inline script/style blocks: got %q, want %q", csp.Header(), baseline.Header())
+	}
+}
+
+func TestGenerateDoesNotMutateBaseline(t *testing.T) {
+	baseline := config.DefaultCSP()
+	before := baseline.Header()
+
+	Generate(baseline, []byte(`<script>alert(1)</script>`))
+
+	if baseline.Header() != before {
+		t.Error("Generate mutated the baseline CSP passed into it")
+	}
+}
+
+func contains(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHashSourceFormat(t *testing.T) {
+	source := hashSource([]byte("alert(1)"))
+	if !strings.HasPrefix(source, "'sha256-") || !strings.HasSuffix(source, "'") {
+		t.Errorf("hashSource() = %q, want it wrapped as 'sha256-...'", source)
+	}
+}
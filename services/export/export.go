@@ -0,0 +1,172 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package export renders every route the web server would serve into a
+// static directory tree, so a repository can be published without running
+// a long-lived server process.
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/andreaskoch/allmark2/common/index"
+	"github.com/andreaskoch/allmark2/common/logger"
+	"github.com/andreaskoch/allmark2/common/util/fsutil"
+)
+
+// route is a single page to render, identified by the url it would be
+// served under and the handler that renders it.
+type route struct {
+	url     string
+	handler http.HandlerFunc
+}
+
+// Exporter walks an item index and renders every route the web server would
+// serve into a directory tree on disk.
+type Exporter struct {
+	logger      logger.Logger
+	itemIndex   *index.Index
+	themeFolder string
+	routes      []route
+}
+
+// New creates an Exporter for the given item index. The url/handler pairs to
+// render are registered afterwards with AddRoute, typically assembled from
+// the same handler.* constructors the server wires into its request router.
+func New(logger logger.Logger, itemIndex *index.Index, themeFolder string) *Exporter {
+	return &Exporter{
+		logger:      logger,
+		itemIndex:   itemIndex,
+		themeFolder: themeFolder,
+	}
+}
+
+// AddRoute registers a single url/handler pair to be rendered on Export.
+func (exporter *Exporter) AddRoute(url string, handler http.HandlerFunc) {
+	exporter.routes = append(exporter.routes, route{url: url, handler: handler})
+}
+
+// manifestEntry records the output path and content hash of a single
+// rendered route.
+type manifestEntry struct {
+	Route string `json:"route"`
+	Path  string `json:"path"`
+	Hash  string `json:"hash"`
+}
+
+// Export renders every registered route into outDir, copies the theme
+// folder verbatim and writes a manifest.json listing every output file with
+// its content hash.
+func (exporter *Exporter) Export(outDir string) error {
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("unable to create output directory %q: %v", outDir, err)
+	}
+
+	manifest := make([]manifestEntry, 0, len(exporter.routes))
+
+	for _, r := range exporter.routes {
+
+		outputPath, body, err := exporter.renderRoute(outDir, r)
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(outDir, outputPath)
+		if err != nil {
+			relativePath = outputPath
+		}
+
+		manifest = append(manifest, manifestEntry{
+			Route: r.url,
+			Path:  relativePath,
+			Hash:  hash(body),
+		})
+
+		exporter.logger.Info("Exported %q to %q\n", r.url, outputPath)
+	}
+
+	if exporter.themeFolder != "" && fsutil.DirectoryExists(exporter.themeFolder) {
+		if err := fsutil.CopyDirectory(exporter.themeFolder, filepath.Join(outDir, "theme")); err != nil {
+			return fmt.Errorf("unable to copy theme folder: %v", err)
+		}
+	}
+
+	return exporter.writeManifest(outDir, manifest)
+}
+
+// renderRoute invokes r.handler against an in-memory response recorder and
+// writes the result to outDir, preserving pretty URLs by writing
+// route/index.html for extension-less routes.
+func (exporter *Exporter) renderRoute(outDir string, r route) (outputPath string, body []byte, err error) {
+
+	request := httptest.NewRequest(http.MethodGet, r.url, nil)
+	recorder := httptest.NewRecorder()
+
+	r.handler(recorder, request)
+
+	outputPath = filepath.Join(outDir, outputFilePath(r.url))
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return "", nil, fmt.Errorf("unable to create directory for %q: %v", r.url, err)
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create %q: %v", outputPath, err)
+	}
+	defer file.Close()
+
+	body = recorder.Body.Bytes()
+	if _, err := io.Copy(file, recorder.Body); err != nil {
+		return "", nil, fmt.Errorf("unable to write %q: %v", outputPath, err)
+	}
+
+	return outputPath, body, nil
+}
+
+func (exporter *Exporter) writeManifest(outDir string, manifest []manifestEntry) error {
+
+	manifestPath := filepath.Join(outDir, "manifest.json")
+
+	file, err := os.Create(manifestPath)
+	if err != nil {
+		return fmt.Errorf("unable to create %q: %v", manifestPath, err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
+
+// outputFilePath turns a route url into a relative filesystem path,
+// preserving pretty URLs (routes without a file extension get an
+// index.html written underneath them).
+func outputFilePath(url string) string {
+	path := filepath.FromSlash(url)
+
+	if path == "" || path == string(filepath.Separator) {
+		return "index.html"
+	}
+
+	if filepath.Ext(path) == "" {
+		return filepath.Join(path, "index.html")
+	}
+
+	return path
+}
+
+func hash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return base64.RawURLEncoding.EncodeToString(sum[:])[:16]
+}
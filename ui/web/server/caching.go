@@ -0,0 +1,108 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/andreaskoch/allmark2/common/index"
+	"github.com/andreaskoch/allmark2/ui/web/server/caching"
+)
+
+// cachingMiddleware answers 304 Not Modified straight out of cache whenever
+// possible, without ever invoking next, and otherwise renders the response,
+// stores its hash in cache and serves it with ETag/Last-Modified headers so
+// the next matching request can be served without re-rendering.
+func cachingMiddleware(cache *caching.Cache, itemIndex *index.Index, format string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		route := r.URL.Path
+		modified := lastModifiedForRoute(itemIndex, route)
+
+		if hash, ok := cache.Peek(route, format, modified); ok && caching.IsNotModified(r, hash, modified) {
+			caching.WriteCachingHeaders(w, r, hash, modified)
+			return
+		}
+
+		recorder := &bytes.Buffer{}
+		headerRecorder := &headerOnlyRecorder{header: make(http.Header)}
+
+		next.ServeHTTP(&bufferingRecorder{ResponseWriter: headerRecorder, buffer: recorder}, r)
+
+		body := recorder.Bytes()
+		hash := caching.Hash(body)
+		cache.Store(route, format, modified, hash)
+
+		for key, values := range headerRecorder.header {
+			if key == "Last-Modified" {
+				// superseded below by the value derived from item metadata
+				continue
+			}
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+
+		if caching.WriteCachingHeaders(w, r, hash, modified) {
+			return
+		}
+
+		if headerRecorder.status != 0 {
+			w.WriteHeader(headerRecorder.status)
+		}
+
+		w.Write(body)
+	})
+}
+
+// headerOnlyRecorder captures headers and the status code without writing
+// anything to the underlying connection.
+type headerOnlyRecorder struct {
+	header http.Header
+	status int
+}
+
+func (r *headerOnlyRecorder) Header() http.Header         { return r.header }
+func (r *headerOnlyRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (r *headerOnlyRecorder) WriteHeader(status int)      { r.status = status }
+
+// bufferingRecorder forwards header calls to the wrapped ResponseWriter
+// while capturing the written body in buffer.
+type bufferingRecorder struct {
+	http.ResponseWriter
+	buffer *bytes.Buffer
+}
+
+func (r *bufferingRecorder) Write(b []byte) (int, error) {
+	return r.buffer.Write(b)
+}
+
+// lastModifiedForRoute derives the Last-Modified value for route from the
+// matching item's own date, or the newest date among its children for
+// routes that render a subtree (e.g. tag/sitemap pages).
+func lastModifiedForRoute(itemIndex *index.Index, route string) time.Time {
+
+	item := itemIndex.ItemForRoute(route)
+	if item == nil {
+		return time.Time{}
+	}
+
+	return latestModified(item)
+}
+
+func latestModified(item *index.Item) time.Time {
+
+	latest := item.MetaData.Date
+
+	for _, child := range item.Childs {
+		if childLatest := latestModified(child); childLatest.After(latest) {
+			latest = childLatest
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,189 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package caching provides content-addressed ETag and Last-Modified support
+// for the handlers that render items, so unchanged responses can be answered
+// with 304 Not Modified instead of being re-rendered and re-sent.
+package caching
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/andreaskoch/allmark2/dataaccess"
+)
+
+// key identifies a single cached rendering of an item.
+type key struct {
+	route    string
+	format   string
+	modified int64
+}
+
+// entry is the cached hash for a given key.
+type entry struct {
+	key  key
+	hash string
+}
+
+// Cache is a small LRU keyed by (route, format, mtime) that holds the
+// content hash of the last rendering of an item. It is invalidated
+// automatically whenever the dataaccess.UpdateHub reports a change.
+type Cache struct {
+	mutex    sync.Mutex
+	capacity int
+	entries  map[key]*list.Element
+	order    *list.List
+}
+
+// New creates a Cache with the given capacity and subscribes to updateHub so
+// entries are dropped as soon as the underlying item changes.
+func New(capacity int, updateHub dataaccess.UpdateHub) *Cache {
+
+	cache := &Cache{
+		capacity: capacity,
+		entries:  make(map[key]*list.Element),
+		order:    list.New(),
+	}
+
+	updateHub.Subscribe(func(route string) {
+		cache.invalidate(route)
+	})
+
+	return cache
+}
+
+// Peek returns the previously stored hash for route/format/mtime without
+// rendering anything. Callers use this to answer 304 Not Modified without
+// ever invoking the handler that would otherwise re-render the response.
+func (cache *Cache) Peek(route, format string, modified time.Time) (hash string, ok bool) {
+
+	k := key{route: route, format: format, modified: modified.UnixNano()}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, ok := cache.entries[k]
+	if !ok {
+		return "", false
+	}
+
+	cache.order.MoveToFront(element)
+	return element.Value.(*entry).hash, true
+}
+
+// Store records the hash of a freshly rendered response for route/format/mtime.
+func (cache *Cache) Store(route, format string, modified time.Time, hash string) {
+
+	k := key{route: route, format: format, modified: modified.UnixNano()}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, ok := cache.entries[k]; ok {
+		element.Value.(*entry).hash = hash
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	element := cache.order.PushFront(&entry{key: k, hash: hash})
+	cache.entries[k] = element
+
+	for cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		if oldest == nil {
+			break
+		}
+
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*entry).key)
+	}
+}
+
+// invalidate drops every cached entry for the given route.
+func (cache *Cache) invalidate(route string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for k, element := range cache.entries {
+		if k.route == route {
+			cache.order.Remove(element)
+			delete(cache.entries, k)
+		}
+	}
+}
+
+// Clear drops every cached entry regardless of route. Callers that cannot
+// reliably map a change back to the route(s) it affects (e.g. a filesystem
+// watcher reporting a path, not a web path) should flush the whole cache
+// instead of leaving stale entries behind.
+func (cache *Cache) Clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.entries = make(map[key]*list.Element)
+	cache.order = list.New()
+}
+
+// Hash returns a stable, URL-safe, truncated sha256 hash of body suitable
+// for use as an ETag value.
+func Hash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return base64.RawURLEncoding.EncodeToString(sum[:])[:16]
+}
+
+// WriteCachingHeaders sets ETag and Last-Modified on w and returns true if
+// the request can be answered with 304 Not Modified (in which case the
+// caller must not write a response body).
+func WriteCachingHeaders(w http.ResponseWriter, r *http.Request, hash string, lastModified time.Time) (notModified bool) {
+
+	etag := `"` + hash + `"`
+	w.Header().Set("ETag", etag)
+
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if match != etag {
+			return false
+		}
+
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	if since := r.Header.Get("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(since); err == nil && !lastModified.After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsNotModified reports whether the request's conditional headers already
+// match hash/lastModified, without writing anything to w. Used to decide
+// whether rendering can be skipped entirely.
+func IsNotModified(r *http.Request, hash string, lastModified time.Time) bool {
+
+	etag := `"` + hash + `"`
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		return match == etag
+	}
+
+	if since := r.Header.Get("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(since); err == nil {
+			return !lastModified.After(t)
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,186 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package caching
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHashIsStableAndContentAddressed(t *testing.T) {
+	a := Hash([]byte("hello"))
+	b := Hash([]byte("hello"))
+	c := Hash([]byte("world"))
+
+	if a != b {
+		t.Errorf("Hash is not stable for identical input: %q != %q", a, b)
+	}
+
+	if a == c {
+		t.Errorf("Hash did not change for different input: %q == %q", a, c)
+	}
+}
+
+func TestIsNotModifiedByETag(t *testing.T) {
+	hash := Hash([]byte("body"))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"`+hash+`"`)
+
+	if !IsNotModified(r, hash, time.Time{}) {
+		t.Error("expected a matching If-None-Match to be reported as not modified")
+	}
+
+	r.Header.Set("If-None-Match", `"something-else"`)
+	if IsNotModified(r, hash, time.Time{}) {
+		t.Error("expected a mismatching If-None-Match to not be reported as not modified")
+	}
+}
+
+func TestIsNotModifiedByDate(t *testing.T) {
+	hash := Hash([]byte("body"))
+	modified := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-Modified-Since", modified.Format(http.TimeFormat))
+
+	if !IsNotModified(r, hash, modified) {
+		t.Error("expected If-Modified-Since equal to the last modified time to be reported as not modified")
+	}
+
+	if !IsNotModified(r, hash, modified.Add(-time.Hour)) {
+		t.Error("expected an earlier last modified time to be reported as not modified")
+	}
+
+	if IsNotModified(r, hash, modified.Add(time.Hour)) {
+		t.Error("expected a later last modified time to not be reported as not modified")
+	}
+}
+
+func TestIsNotModifiedWithoutConditionalHeaders(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if IsNotModified(r, Hash([]byte("body")), time.Now()) {
+		t.Error("expected a request without conditional headers to never be reported as not modified")
+	}
+}
+
+func TestWriteCachingHeadersSetsETagAndLastModified(t *testing.T) {
+	hash := Hash([]byte("body"))
+	modified := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	notModified := WriteCachingHeaders(w, r, hash, modified)
+
+	if notModified {
+		t.Fatal("expected a request without conditional headers to not be answered with 304")
+	}
+
+	if got := w.Header().Get("ETag"); got != `"`+hash+`"` {
+		t.Errorf("ETag = %q, want %q", got, `"`+hash+`"`)
+	}
+
+	if got := w.Header().Get("Last-Modified"); got != modified.UTC().Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q, want %q", got, modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+func TestWriteCachingHeadersAnswers304OnMatch(t *testing.T) {
+	hash := Hash([]byte("body"))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"`+hash+`"`)
+	w := httptest.NewRecorder()
+
+	if !WriteCachingHeaders(w, r, hash, time.Time{}) {
+		t.Fatal("expected a matching If-None-Match to be answered with 304")
+	}
+
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotModified)
+	}
+}
+
+type noopUpdateHub struct{}
+
+func (noopUpdateHub) Subscribe(func(route string)) {}
+func (noopUpdateHub) Update(route string)          {}
+
+func TestCachePeekAndStore(t *testing.T) {
+	cache := New(10, noopUpdateHub{})
+	modified := time.Now()
+
+	if _, ok := cache.Peek("/blog/post", "html", modified); ok {
+		t.Fatal("expected Peek to miss on an empty cache")
+	}
+
+	cache.Store("/blog/post", "html", modified, "abc123")
+
+	hash, ok := cache.Peek("/blog/post", "html", modified)
+	if !ok || hash != "abc123" {
+		t.Fatalf("Peek = (%q, %v), want (%q, true)", hash, ok, "abc123")
+	}
+
+	// a different mtime is a different cache key
+	if _, ok := cache.Peek("/blog/post", "html", modified.Add(time.Hour)); ok {
+		t.Error("expected Peek to miss for a route/format/mtime combination that was never stored")
+	}
+}
+
+func TestCacheStoreEvictsOldestBeyondCapacity(t *testing.T) {
+	cache := New(1, noopUpdateHub{})
+	modified := time.Now()
+
+	cache.Store("/a", "html", modified, "hash-a")
+	cache.Store("/b", "html", modified, "hash-b")
+
+	if _, ok := cache.Peek("/a", "html", modified); ok {
+		t.Error("expected the oldest entry to be evicted once capacity was exceeded")
+	}
+
+	if hash, ok := cache.Peek("/b", "html", modified); !ok || hash != "hash-b" {
+		t.Error("expected the most recently stored entry to remain cached")
+	}
+}
+
+func TestCacheClearDropsEveryRoute(t *testing.T) {
+	cache := New(10, noopUpdateHub{})
+	modified := time.Now()
+
+	cache.Store("/a", "html", modified, "hash-a")
+	cache.Store("/b", "html", modified, "hash-b")
+
+	cache.Clear()
+
+	if _, ok := cache.Peek("/a", "html", modified); ok {
+		t.Error("expected Clear to drop entries for /a")
+	}
+
+	if _, ok := cache.Peek("/b", "html", modified); ok {
+		t.Error("expected Clear to drop entries for /b")
+	}
+}
+
+func TestCacheInvalidateDropsOnlyMatchingRoute(t *testing.T) {
+	cache := New(10, noopUpdateHub{})
+	modified := time.Now()
+
+	cache.Store("/a", "html", modified, "hash-a")
+	cache.Store("/b", "html", modified, "hash-b")
+
+	cache.invalidate("/a")
+
+	if _, ok := cache.Peek("/a", "html", modified); ok {
+		t.Error("expected invalidate to drop the matching route")
+	}
+
+	if _, ok := cache.Peek("/b", "html", modified); !ok {
+		t.Error("expected invalidate to leave other routes untouched")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreaskoch/allmark2/common/logger"
+	"github.com/andreaskoch/allmark2/dataaccess"
+	"github.com/andreaskoch/allmark2/ui/web/server/caching"
+	"github.com/andreaskoch/allmark2/ui/web/server/update"
+
+	"github.com/go-fsnotify/fsnotify"
+)
+
+// ReloadScript is injected into every item page while the server is running
+// in development mode. It opens a websocket connection to the update handler
+// and reloads the page as soon as a message is received.
+const ReloadScript = `<script>
+(function() {
+	var socket = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+	socket.onmessage = function() {
+		location.reload();
+	};
+})();
+</script>`
+
+// newDevWatcher creates a filesystem watcher that keeps the item index in
+// sync with the content repository and notifies connected browsers whenever
+// something changed.
+func newDevWatcher(logger logger.Logger, repositoryPath string, updateHub dataaccess.UpdateHub, reloadHub *update.Hub, responseCache *caching.Cache) (*devWatcher, error) {
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// fsnotify only watches the directory it is told about, not its
+	// subdirectories, so walk the tree and add every folder we find. Since
+	// a site is usually organized as one folder per item, watching only
+	// the top-level directory would silently miss almost every change.
+	if err := addRecursive(watcher, repositoryPath); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	return &devWatcher{
+		logger:        logger,
+		watcher:       watcher,
+		updateHub:     updateHub,
+		reloadHub:     reloadHub,
+		responseCache: responseCache,
+	}, nil
+}
+
+type devWatcher struct {
+	logger        logger.Logger
+	watcher       *fsnotify.Watcher
+	updateHub     dataaccess.UpdateHub
+	reloadHub     *update.Hub
+	responseCache *caching.Cache
+}
+
+// run watches the content repository for changes until stop is closed.
+func (dev *devWatcher) run(stop chan struct{}) {
+	for {
+		select {
+		case event, ok := <-dev.watcher.Events:
+			if !ok {
+				return
+			}
+
+			dev.logger.Debug("Detected file system change: %s", event)
+
+			// a newly created directory needs to be watched too, otherwise
+			// changes made inside it would go unnoticed
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := addRecursive(dev.watcher, event.Name); err != nil {
+						dev.logger.Error("Unable to watch new directory %q: %s", event.Name, err)
+					}
+				}
+			}
+
+			// incrementally reindex the changed item through the existing
+			// update hub and let connected browsers know about it
+			dev.updateHub.Update(event.Name)
+
+			// event.Name is a filesystem path, not a web route, so the
+			// responseCache (which is keyed and invalidated by route) can't
+			// be trusted to drop the right entry on its own; flush it
+			// entirely rather than risk serving a stale page after an edit
+			dev.responseCache.Clear()
+
+			dev.reloadHub.Broadcast("reload")
+
+		case err, ok := <-dev.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			dev.logger.Error("File system watcher error: %s", err)
+
+		case <-stop:
+			dev.watcher.Close()
+			return
+		}
+	}
+}
+
+// addRecursive adds root and every subdirectory underneath it to watcher.
+func addRecursive(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return watcher.Add(path)
+		}
+
+		return nil
+	})
+}
+
+// devReloadMiddleware injects ReloadScript into every HTML response so the
+// browser opens a websocket connection to the update handler and reloads as
+// soon as dev mode broadcasts a change. It is only wired in when
+// config.Server.Dev is enabled.
+func devReloadMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		recorder := &bytes.Buffer{}
+		headerRecorder := &headerOnlyRecorder{header: make(http.Header)}
+
+		next.ServeHTTP(&bufferingRecorder{ResponseWriter: headerRecorder, buffer: recorder}, r)
+
+		body := recorder.Bytes()
+		if isHtmlResponse(headerRecorder.header) {
+			body = injectReloadScript(body)
+		}
+
+		for key, values := range headerRecorder.header {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+
+		if headerRecorder.status != 0 {
+			w.WriteHeader(headerRecorder.status)
+		}
+
+		w.Write(body)
+	})
+}
+
+// injectReloadScript appends ReloadScript just before the closing </body>
+// tag, or at the very end of body if none is found.
+func injectReloadScript(body []byte) []byte {
+	const closingBodyTag = "</body>"
+
+	index := bytes.LastIndex(body, []byte(closingBodyTag))
+	if index == -1 {
+		return append(body, []byte(ReloadScript)...)
+	}
+
+	injected := make([]byte, 0, len(body)+len(ReloadScript))
+	injected = append(injected, body[:index]...)
+	injected = append(injected, []byte(ReloadScript)...)
+	injected = append(injected, body[index:]...)
+	return injected
+}
+
+// isHtmlResponse reports whether header advertises an HTML content type.
+func isHtmlResponse(header http.Header) bool {
+	return strings.Contains(header.Get("Content-Type"), "html")
+}
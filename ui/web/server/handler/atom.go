@@ -0,0 +1,128 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/andreaskoch/allmark2/common/config"
+	"github.com/andreaskoch/allmark2/common/index"
+	"github.com/andreaskoch/allmark2/common/logger"
+	"github.com/andreaskoch/allmark2/common/paths"
+	"github.com/andreaskoch/allmark2/services/conversion"
+)
+
+// atomFeed is the root element of an Atom 1.0 feed.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",cdata"`
+}
+
+// NewAtomHandler creates a new handler for the Atom feed route.
+func NewAtomHandler(logger logger.Logger, config *config.Config, itemIndex *index.Index, patherFactory paths.PatherFactory, converter conversion.Converter) *AtomHandler {
+	return &AtomHandler{
+		logger:        logger,
+		config:        config,
+		itemIndex:     itemIndex,
+		patherFactory: patherFactory,
+		converter:     converter,
+	}
+}
+
+type AtomHandler struct {
+	logger        logger.Logger
+	config        *config.Config
+	itemIndex     *index.Index
+	patherFactory paths.PatherFactory
+	converter     conversion.Converter
+}
+
+func (handler *AtomHandler) Func() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		root := handler.itemIndex.Root()
+		if root == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		pather := handler.patherFactory.Absolute("")
+
+		feed := &atomFeed{
+			Title:   root.Title,
+			ID:      handler.tagUri(""),
+			Updated: root.MetaData.Date.Format(time.RFC3339),
+			Author:  atomPerson{Name: handler.config.Author()},
+			Links: []atomLink{
+				{Rel: "self", Href: pather.Path(AtomHandlerRoute)},
+				{Rel: "alternate", Href: pather.Path("")},
+			},
+		}
+
+		for _, item := range FeedItems(handler.itemIndex) {
+
+			entry := atomEntry{
+				Title:   item.Title,
+				ID:      handler.tagUri(item.RelativePath()),
+				Updated: item.MetaData.Date.Format(time.RFC3339),
+				Links: []atomLink{
+					{Rel: "alternate", Href: pather.Path(item.RelativePath())},
+				},
+				Content: atomContent{
+					Type: "html",
+					Body: handler.converter.Convert(item),
+				},
+			}
+
+			feed.Entries = append(feed.Entries, entry)
+		}
+
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+
+		if err := encoder.Encode(feed); err != nil {
+			handler.logger.Error("Unable to render the atom feed: %v", err)
+			http.Error(w, "Unable to render the atom feed", http.StatusInternalServerError)
+		}
+	}
+}
+
+// tagUri builds a tag: URI (RFC 4151) for the given path using the
+// configured domain and domain start date.
+func (handler *AtomHandler) tagUri(path string) string {
+	return fmt.Sprintf("tag:%s,%s:%s", handler.config.OriginalDomain, handler.config.DomainStartDate.Format("2006-01-02"), path)
+}
@@ -0,0 +1,32 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"sort"
+
+	"github.com/andreaskoch/allmark2/common/index"
+)
+
+// maxFeedEntries caps the number of entries rendered into a feed.
+const maxFeedEntries = 50
+
+// FeedItems returns the items from itemIndex ordered newest-first and capped
+// at maxFeedEntries. RssHandler and AtomHandler both build their entries
+// from this so the two feed formats always agree on order and length.
+func FeedItems(itemIndex *index.Index) []*index.Item {
+
+	items := append([]*index.Item{}, itemIndex.Items()...)
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].MetaData.Date.After(items[j].MetaData.Date)
+	})
+
+	if len(items) > maxFeedEntries {
+		items = items[:maxFeedEntries]
+	}
+
+	return items
+}
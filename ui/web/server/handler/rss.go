@@ -0,0 +1,101 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/andreaskoch/allmark2/common/config"
+	"github.com/andreaskoch/allmark2/common/index"
+	"github.com/andreaskoch/allmark2/common/logger"
+	"github.com/andreaskoch/allmark2/common/paths"
+	"github.com/andreaskoch/allmark2/services/conversion"
+)
+
+// rssFeed is the root element of an RSS 2.0 feed.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Guid        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description"`
+}
+
+// NewRssHandler creates a new handler for the RSS feed route.
+func NewRssHandler(logger logger.Logger, config *config.Config, itemIndex *index.Index, patherFactory paths.PatherFactory, converter conversion.Converter) *RssHandler {
+	return &RssHandler{
+		logger:        logger,
+		config:        config,
+		itemIndex:     itemIndex,
+		patherFactory: patherFactory,
+		converter:     converter,
+	}
+}
+
+type RssHandler struct {
+	logger        logger.Logger
+	config        *config.Config
+	itemIndex     *index.Index
+	patherFactory paths.PatherFactory
+	converter     conversion.Converter
+}
+
+func (handler *RssHandler) Func() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		root := handler.itemIndex.Root()
+		if root == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		pather := handler.patherFactory.Absolute("")
+
+		feed := &rssFeed{
+			Version: "2.0",
+			Channel: rssChannel{
+				Title:       root.Title,
+				Link:        pather.Path(""),
+				Description: handler.config.Author(),
+			},
+		}
+
+		for _, item := range FeedItems(handler.itemIndex) {
+
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title:       item.Title,
+				Link:        pather.Path(item.RelativePath()),
+				Guid:        pather.Path(item.RelativePath()),
+				PubDate:     item.MetaData.Date.Format(time.RFC1123Z),
+				Description: handler.converter.Convert(item),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+
+		if err := encoder.Encode(feed); err != nil {
+			handler.logger.Error("Unable to render the rss feed: %v", err)
+			http.Error(w, "Unable to render the rss feed", http.StatusInternalServerError)
+		}
+	}
+}
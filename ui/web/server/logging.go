@@ -0,0 +1,78 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/andreaskoch/allmark2/common/logger"
+
+	"github.com/google/uuid"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+// loggingMiddleware logs every request handled by next with its method,
+// path, status code, response size, duration, remote address and a
+// generated request id which is also echoed back to the client.
+func loggingMiddleware(logger logger.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		requestId := uuid.New().String()
+		w.Header().Set(requestIdHeader, requestId)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+		duration := time.Since(start)
+
+		logger.With(
+			"request-id", requestId,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytesWritten,
+			"duration", duration.String(),
+			"remote-addr", r.RemoteAddr,
+		).Info("%s %s", r.Method, r.URL.Path)
+	})
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code and
+// number of bytes written so they can be logged after the handler returns.
+type statusRecorder struct {
+	http.ResponseWriter
+	status       int
+	bytesWritten int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(b []byte) (int, error) {
+	n, err := recorder.ResponseWriter.Write(b)
+	recorder.bytesWritten += n
+	return n, err
+}
+
+// Hijack delegates to the underlying ResponseWriter so websocket upgrades
+// (e.g. the update hub route) keep working through this middleware. Go only
+// promotes methods declared on the embedded http.ResponseWriter interface,
+// not http.Hijacker, so without this the type assertion in
+// code.google.com/p/go.net/websocket's Handler.ServeHTTP would panic.
+func (recorder *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := recorder.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
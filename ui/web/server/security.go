@@ -0,0 +1,69 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/andreaskoch/allmark2/common/config"
+	"github.com/andreaskoch/allmark2/services/cspgenerator"
+)
+
+// securityHeadersMiddleware adds a Content-Security-Policy, augmented with a
+// 'sha256-...' source for every inline <script>/<style> block actually
+// present in an HTML response, along with a fixed set of hardening headers.
+func securityHeadersMiddleware(baseline config.CSP, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// websocket upgrades (the update hub route) need the real,
+		// hijackable ResponseWriter: buffering the response below would
+		// swallow the connection the upgrade hijacks out from under it.
+		// There is nothing to scan for inline script/style in an upgrade
+		// response, so just pass it through untouched.
+		if isWebsocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &bytes.Buffer{}
+		headerRecorder := &headerOnlyRecorder{header: make(http.Header)}
+
+		next.ServeHTTP(&bufferingRecorder{ResponseWriter: headerRecorder, buffer: recorder}, r)
+
+		body := recorder.Bytes()
+
+		csp := baseline
+		if isHtmlResponse(headerRecorder.header) {
+			csp = cspgenerator.Generate(baseline, body)
+		}
+
+		for key, values := range headerRecorder.header {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+
+		header := w.Header()
+		header.Set("Content-Security-Policy", csp.Header())
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "no-referrer-when-downgrade")
+		header.Set("Permissions-Policy", "geolocation=(), microphone=(), camera=()")
+
+		if headerRecorder.status != 0 {
+			w.WriteHeader(headerRecorder.status)
+		}
+
+		w.Write(body)
+	})
+}
+
+// isWebsocketUpgrade reports whether r is requesting a websocket upgrade,
+// per RFC 6455: a "Connection: Upgrade" header alongside "Upgrade: websocket".
+func isWebsocketUpgrade(r *http.Request) bool {
+	return strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
@@ -6,6 +6,7 @@ package server
 
 import (
 	"code.google.com/p/go.net/websocket"
+	"context"
 	"fmt"
 	"github.com/andreaskoch/allmark2/common/config"
 	"github.com/andreaskoch/allmark2/common/index"
@@ -14,14 +15,23 @@ import (
 	"github.com/andreaskoch/allmark2/common/paths/webpaths"
 	"github.com/andreaskoch/allmark2/common/util/fsutil"
 	"github.com/andreaskoch/allmark2/dataaccess"
+	"github.com/andreaskoch/allmark2/dataaccess/git"
+	"github.com/andreaskoch/allmark2/internal/listenfd"
 	"github.com/andreaskoch/allmark2/services/conversion"
 	"github.com/andreaskoch/allmark2/services/search"
+	"github.com/andreaskoch/allmark2/ui/web/server/caching"
 	"github.com/andreaskoch/allmark2/ui/web/server/handler"
 	"github.com/andreaskoch/allmark2/ui/web/server/update"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/crypto/acme/autocert"
 	"math"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 const (
@@ -37,8 +47,10 @@ const (
 	SitemapHandlerRoute               = "/sitemap.html"
 	XmlSitemapHandlerRoute            = "/sitemap.xml"
 	RssHandlerRoute                   = "/feed.rss"
+	AtomHandlerRoute                  = "/feed.atom"
 	RobotsTxtHandlerRoute             = "/robots.txt"
 	DebugHandlerRoute                 = "/debug/index"
+	GitHookHandlerRoute               = "/hooks/git"
 	SearchHandlerRoute                = "/search"
 	OpenSearchDescriptionHandlerRoute = "/opensearch.xml"
 
@@ -47,6 +59,10 @@ const (
 
 	// Static Routes
 	ThemeFolderRoute = "/theme"
+
+	// responseCacheCapacity is the number of rendered item hashes kept in
+	// memory for ETag/Last-Modified comparisons.
+	responseCacheCapacity = 2048
 )
 
 func New(logger logger.Logger, config *config.Config, itemIndex *index.Index, converter conversion.Converter, searcher *search.ItemSearch, updateHub dataaccess.UpdateHub) (*Server, error) {
@@ -54,7 +70,7 @@ func New(logger logger.Logger, config *config.Config, itemIndex *index.Index, co
 	// pather factory
 	patherFactory := webpaths.NewFactory(logger, itemIndex)
 
-	return &Server{
+	server := &Server{
 		config:        config,
 		logger:        logger,
 		patherFactory: patherFactory,
@@ -62,8 +78,26 @@ func New(logger logger.Logger, config *config.Config, itemIndex *index.Index, co
 		converter:     converter,
 		searcher:      searcher,
 		updateHub:     updateHub,
-	}, nil
+	}
+
+	// when the content is tracked in a git repository, wire it in so
+	// Start() can expose the push webhook route and poll for upstream
+	// changes
+	if config.Repository.Type == "git" {
+		gitRepository, err := git.New(logger.With("component", "git"), git.Config{
+			Url:           config.Repository.Git.Url,
+			WorkDir:       config.Repository.Git.WorkDir,
+			PollInterval:  config.Repository.Git.PollInterval,
+			WebhookSecret: config.Repository.Git.WebhookSecret,
+		}, updateHub)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to set up the git repository: %v", err)
+		}
+
+		server.SetGitRepository(gitRepository)
+	}
 
+	return server, nil
 }
 
 type Server struct {
@@ -76,12 +110,24 @@ type Server struct {
 	converter     conversion.Converter
 	searcher      *search.ItemSearch
 	updateHub     dataaccess.UpdateHub
+	gitRepository *git.Repository
 }
 
 func (server *Server) IsRunning() bool {
 	return server.isRunning
 }
 
+// SetGitRepository wires a git-backed content repository into the server so
+// it can expose a webhook route for push notifications. It is a no-op when
+// the configured repository backend is not "git".
+func (server *Server) SetGitRepository(repo *git.Repository) {
+	if server.config.Repository.Type != "git" {
+		return
+	}
+
+	server.gitRepository = repo
+}
+
 func (server *Server) Start() chan error {
 	result := make(chan error)
 
@@ -91,45 +137,184 @@ func (server *Server) Start() chan error {
 		// register requst routers
 		requestRouter := mux.NewRouter()
 
+		// response cache used to answer unchanged requests with 304 Not Modified
+		responseCache := caching.New(responseCacheCapacity, server.updateHub)
+
 		// websocket update handler
-		updateHub := update.NewHub(server.logger, server.updateHub)
+		updateHub := update.NewHub(server.logger.With("handler", "update-hub"), server.updateHub)
 		go updateHub.Run()
 
-		updateHandler := handler.NewUpdateHandler(server.logger, server.config, server.itemIndex, server.patherFactory, server.converter, updateHub)
+		updateHandler := handler.NewUpdateHandler(server.logger.With("handler", "update"), server.config, server.itemIndex, server.patherFactory, server.converter, updateHub)
 		requestRouter.Handle(UpdateHandlerRoute, websocket.Handler(updateHandler.Func()))
 
 		// serve auxiliary dynamic files
-		requestRouter.HandleFunc(RobotsTxtHandlerRoute, handler.NewRobotsTxtHandler(server.logger, server.config, server.itemIndex, server.patherFactory).Func())
-		requestRouter.HandleFunc(XmlSitemapHandlerRoute, handler.NewXmlSitemapHandler(server.logger, server.config, server.itemIndex, server.patherFactory).Func())
-		requestRouter.HandleFunc(TagmapHandlerRoute, handler.NewTagsHandler(server.logger, server.config, server.itemIndex, server.patherFactory).Func())
-		requestRouter.HandleFunc(SitemapHandlerRoute, handler.NewSitemapHandler(server.logger, server.config, server.itemIndex, server.patherFactory).Func())
-		requestRouter.HandleFunc(DebugHandlerRoute, handler.NewDebugHandler(server.logger, server.itemIndex).Func())
-		requestRouter.HandleFunc(RssHandlerRoute, handler.NewRssHandler(server.logger, server.config, server.itemIndex, server.patherFactory, server.converter).Func())
-		requestRouter.HandleFunc(PrintHandlerRoute, handler.NewPrintHandler(server.logger, server.config, server.itemIndex, server.patherFactory, server.converter).Func())
-		requestRouter.HandleFunc(SearchHandlerRoute, handler.NewSearchHandler(server.logger, server.config, server.patherFactory, server.itemIndex, server.searcher).Func())
-		requestRouter.HandleFunc(OpenSearchDescriptionHandlerRoute, handler.NewOpenSearchDescriptionHandler(server.logger, server.config, server.patherFactory, server.itemIndex).Func())
-		requestRouter.HandleFunc(TypeAheadSearchHandlerRoute, handler.NewTypeAheadSearchHandler(server.logger, server.config, server.patherFactory, server.itemIndex, server.searcher).Func())
-		requestRouter.HandleFunc(TypeAheadTitlesHandlerRoute, handler.NewTypeAheadTitlesHandler(server.logger, server.config, server.patherFactory, server.itemIndex).Func())
+		requestRouter.HandleFunc(RobotsTxtHandlerRoute, handler.NewRobotsTxtHandler(server.logger.With("handler", "robots-txt"), server.config, server.itemIndex, server.patherFactory).Func())
+		requestRouter.Handle(XmlSitemapHandlerRoute, cachingMiddleware(responseCache, server.itemIndex, "xml-sitemap", http.HandlerFunc(handler.NewXmlSitemapHandler(server.logger.With("handler", "xml-sitemap"), server.config, server.itemIndex, server.patherFactory).Func())))
+		requestRouter.HandleFunc(TagmapHandlerRoute, handler.NewTagsHandler(server.logger.With("handler", "tags"), server.config, server.itemIndex, server.patherFactory).Func())
+		requestRouter.HandleFunc(SitemapHandlerRoute, handler.NewSitemapHandler(server.logger.With("handler", "sitemap"), server.config, server.itemIndex, server.patherFactory).Func())
+		requestRouter.HandleFunc(DebugHandlerRoute, handler.NewDebugHandler(server.logger.With("handler", "debug"), server.itemIndex).Func())
+
+		// git-backed content repository: accept push webhooks and poll for changes
+		var stopGitPolling func()
+		if server.gitRepository != nil {
+			requestRouter.HandleFunc(GitHookHandlerRoute, server.gitRepository.WebhookHandler())
+			stopGitPolling = server.gitRepository.Poll()
+		}
+		requestRouter.Handle(RssHandlerRoute, cachingMiddleware(responseCache, server.itemIndex, "rss", http.HandlerFunc(handler.NewRssHandler(server.logger.With("handler", "rss"), server.config, server.itemIndex, server.patherFactory, server.converter).Func())))
+		requestRouter.Handle(AtomHandlerRoute, cachingMiddleware(responseCache, server.itemIndex, "atom", http.HandlerFunc(handler.NewAtomHandler(server.logger.With("handler", "atom"), server.config, server.itemIndex, server.patherFactory, server.converter).Func())))
+		requestRouter.HandleFunc(PrintHandlerRoute, handler.NewPrintHandler(server.logger.With("handler", "print"), server.config, server.itemIndex, server.patherFactory, server.converter).Func())
+		requestRouter.HandleFunc(SearchHandlerRoute, handler.NewSearchHandler(server.logger.With("handler", "search"), server.config, server.patherFactory, server.itemIndex, server.searcher).Func())
+		requestRouter.HandleFunc(OpenSearchDescriptionHandlerRoute, handler.NewOpenSearchDescriptionHandler(server.logger.With("handler", "opensearch-description"), server.config, server.patherFactory, server.itemIndex).Func())
+		requestRouter.HandleFunc(TypeAheadSearchHandlerRoute, handler.NewTypeAheadSearchHandler(server.logger.With("handler", "typeahead-search"), server.config, server.patherFactory, server.itemIndex, server.searcher).Func())
+		requestRouter.HandleFunc(TypeAheadTitlesHandlerRoute, handler.NewTypeAheadTitlesHandler(server.logger.With("handler", "typeahead-titles"), server.config, server.patherFactory, server.itemIndex).Func())
 
 		// serve static files
 		if themeFolder := server.config.ThemeFolder(); fsutil.DirectoryExists(themeFolder) {
 			s := http.StripPrefix(ThemeFolderRoute, http.FileServer(http.Dir(themeFolder)))
-			requestRouter.PathPrefix(ThemeFolderRoute).Handler(s)
+			requestRouter.PathPrefix(ThemeFolderRoute).Handler(cachingMiddleware(responseCache, server.itemIndex, "theme", s))
 		}
 
 		// serve items
-		requestRouter.HandleFunc(RtfHandlerRoute, handler.NewRtfHandler(server.logger, server.config, server.itemIndex, server.patherFactory).Func())
-		requestRouter.HandleFunc(JsonHandlerRoute, handler.NewJsonHandler(server.logger, server.config, server.itemIndex, server.patherFactory, server.converter).Func())
-		requestRouter.HandleFunc(ItemHandlerRoute, handler.NewItemHandler(server.logger, server.config, server.itemIndex, server.patherFactory, server.converter, updateHub).Func())
+		requestRouter.HandleFunc(RtfHandlerRoute, handler.NewRtfHandler(server.logger.With("handler", "rtf"), server.config, server.itemIndex, server.patherFactory).Func())
+		requestRouter.Handle(JsonHandlerRoute, cachingMiddleware(responseCache, server.itemIndex, "json", http.HandlerFunc(handler.NewJsonHandler(server.logger.With("handler", "json"), server.config, server.itemIndex, server.patherFactory, server.converter).Func())))
+
+		// in development mode every item page gets the reload snippet
+		// injected before it is cached/served, so browsers auto-refresh
+		// when the update hub broadcasts a change
+		var itemHandler http.Handler = http.HandlerFunc(handler.NewItemHandler(server.logger.With("handler", "item"), server.config, server.itemIndex, server.patherFactory, server.converter, updateHub).Func())
+		if server.config.Server.Dev {
+			itemHandler = devReloadMiddleware(itemHandler)
+		}
+		requestRouter.Handle(ItemHandlerRoute, cachingMiddleware(responseCache, server.itemIndex, "html", itemHandler))
+
+		// development mode: watch the content repository and tell connected
+		// browsers to reload whenever something changes
+		var devStop chan struct{}
+		if server.config.Server.Dev {
+			dev, err := newDevWatcher(server.logger, server.config.RepositoryPath(), server.updateHub, updateHub, responseCache)
+			if err != nil {
+				server.logger.Error("Unable to start the development watcher: %v", err)
+			} else {
+				devStop = make(chan struct{})
+				go dev.run(devStop)
+				server.logger.Info("Development mode enabled. Watching %q for changes.\n", server.config.RepositoryPath())
+			}
+		}
+
+		// wrap the router with a logging middleware so every request is
+		// recorded with its method, path, status, size, duration and a
+		// generated request id
+		loggedRouter := loggingMiddleware(server.logger, requestRouter)
 
-		// start http server: http
+		// add CSP and the remaining security headers to every response
+		loggedRouter = securityHeadersMiddleware(server.config.CSP(), loggedRouter)
+
+		// start the http server(s)
 		httpBinding := server.getHttpBinding()
-		server.logger.Info("Starting http server %q\n", httpBinding)
+		httpServer := &http.Server{
+			Addr:         httpBinding,
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+
+		var httpsServer *http.Server
+		var certManager *autocert.Manager
 
-		if err := http.ListenAndServe(httpBinding, requestRouter); err != nil {
-			result <- fmt.Errorf("Server failed with error: %v", err)
+		if server.tlsEnabled() {
+			// the http listener only serves ACME challenges and redirects
+			// everything else to https
+			certManager = server.newAutocertManager()
+			httpServer.Handler = redirectToHttpsHandler(certManager, server.config.Server.Https.Port)
+
+			httpsBinding := server.getHttpsBinding()
+			httpsServer = server.newTlsServer(httpsBinding, loggedRouter, certManager)
 		} else {
-			result <- nil
+			httpServer.Handler = loggedRouter
+		}
+
+		// adopt listeners passed in by a supervisor (e.g. systemd socket
+		// activation) instead of opening our own, so in-flight connections
+		// survive a `systemctl reload`-style handoff
+		inheritedListeners, err := listenfd.Listeners()
+		if err != nil {
+			result <- fmt.Errorf("Unable to adopt inherited listeners: %v", err)
+			server.isRunning = false
+			return
+		}
+
+		httpListener, err := firstListener(inheritedListeners, 0, httpBinding)
+		if err != nil {
+			result <- err
+			server.isRunning = false
+			return
+		}
+
+		serverErrors := make(chan error, 1)
+		go func() {
+			server.logger.Info("Starting http server %q\n", httpListener.Addr())
+			if err := httpServer.Serve(httpListener); err != nil && err != http.ErrServerClosed {
+				serverErrors <- fmt.Errorf("Server failed with error: %v", err)
+			} else {
+				serverErrors <- nil
+			}
+		}()
+
+		if httpsServer != nil {
+			httpsListener, err := firstListener(inheritedListeners, 1, httpsServer.Addr)
+			if err != nil {
+				result <- err
+				server.isRunning = false
+				return
+			}
+
+			go func() {
+				server.logger.Info("Starting https server %q\n", httpsListener.Addr())
+
+				var err error
+				if certFile, keyFile := server.config.Server.Https.CertFile, server.config.Server.Https.KeyFile; certFile != "" && keyFile != "" {
+					err = httpsServer.ServeTLS(httpsListener, certFile, keyFile)
+				} else {
+					err = httpsServer.ServeTLS(httpsListener, "", "")
+				}
+
+				if err != nil && err != http.ErrServerClosed {
+					serverErrors <- fmt.Errorf("Https server failed with error: %v", err)
+				} else {
+					serverErrors <- nil
+				}
+			}()
+		}
+
+		// wait for a shutdown signal or a server error
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+		select {
+		case err := <-serverErrors:
+			result <- err
+
+		case sig := <-signals:
+			server.logger.Info("Received %v. Shutting down gracefully.\n", sig)
+
+			if devStop != nil {
+				close(devStop)
+			}
+
+			if stopGitPolling != nil {
+				stopGitPolling()
+			}
+
+			shutdownContext, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := httpServer.Shutdown(shutdownContext); err != nil {
+				result <- fmt.Errorf("Server failed to shut down gracefully: %v", err)
+			} else if httpsServer != nil {
+				result <- httpsServer.Shutdown(shutdownContext)
+			} else {
+				result <- nil
+			}
 		}
 
 		server.isRunning = false
@@ -148,3 +333,19 @@ func (server *Server) getHttpBinding() string {
 
 	return fmt.Sprintf(":%v", port)
 }
+
+// firstListener returns the inherited listener at index, if one was passed
+// in by a supervisor, or opens a new tcp listener on binding otherwise.
+func firstListener(inherited []net.Listener, index int, binding string) (net.Listener, error) {
+
+	if index < len(inherited) {
+		return inherited[index], nil
+	}
+
+	listener, err := net.Listen("tcp", binding)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen on %q: %v", binding, err)
+	}
+
+	return listener, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2014 Andreas Koch. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const wellKnownAcmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// tlsEnabled returns true if the server is configured to serve HTTPS traffic.
+func (server *Server) tlsEnabled() bool {
+	return server.config.Server.Https.Enabled
+}
+
+// getHttpsBinding validates and returns the address the HTTPS listener binds to.
+func (server *Server) getHttpsBinding() string {
+
+	port := server.config.Server.Https.Port
+	if port < 1 || port > math.MaxUint16 {
+		panic(fmt.Sprintf("%q is an invalid value for a port. Ports can only be in the range of %v to %v,", port, 1, math.MaxUint16))
+	}
+
+	return fmt.Sprintf(":%v", port)
+}
+
+// newAutocertManager builds the certificate manager used to obtain and renew
+// certificates automatically for the configured hosts.
+func (server *Server) newAutocertManager() *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(server.config.Server.Https.Hosts...),
+		Cache:      autocert.DirCache(server.config.Server.Https.CacheDirectory),
+	}
+}
+
+// newTlsServer builds the https.Server for the given handler, sourcing its
+// certificates either from the configured cert/key files or, if none are
+// supplied, from autocert.
+func (server *Server) newTlsServer(httpsBinding string, handler http.Handler, certManager *autocert.Manager) *http.Server {
+
+	httpsServer := &http.Server{
+		Addr:         httpsBinding,
+		Handler:      hstsMiddleware(handler),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	if certFile, keyFile := server.config.Server.Https.CertFile, server.config.Server.Https.KeyFile; certFile != "" && keyFile != "" {
+		return httpsServer
+	}
+
+	httpsServer.TLSConfig = certManager.TLSConfig()
+	return httpsServer
+}
+
+// redirectToHttpsHandler serves ACME HTTP-01 challenges when present and
+// redirects every other request to the HTTPS equivalent of the same URL,
+// always pointing at the configured HTTPS port rather than whatever port the
+// request came in on.
+func redirectToHttpsHandler(certManager *autocert.Manager, httpsPort int) http.Handler {
+	challengeHandler := certManager.HTTPHandler(nil)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, wellKnownAcmeChallengePrefix) {
+			challengeHandler.ServeHTTP(w, r)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, fmt.Sprintf("%d", httpsPort))
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// hstsMiddleware adds a Strict-Transport-Security header to every response
+// served over the returned handler.
+func hstsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		next.ServeHTTP(w, r)
+	})
+}